@@ -0,0 +1,67 @@
+package model
+
+import (
+	"github.com/tychoish/grip"
+)
+
+// Action is a single step of a multi-document lifecycle transition (e.g. a
+// write to the task, build, version, patch, or event collections) that can
+// be undone if a later step in the same Chain fails.
+type Action interface {
+	// Do performs the step, recording whatever pre-image it needs in order
+	// to revert itself later.
+	Do() error
+	// Undo reverts the effect of a previously successful Do. It is only
+	// ever called on actions whose Do has already succeeded.
+	Undo() error
+}
+
+// Chain is a sequence of Actions that either all succeed or all have their
+// effects reverted. If an Action in the chain fails, Chain rolls back every
+// preceding Action, in reverse order, before returning the original error.
+type Chain []Action
+
+// Run executes the chain in order, rolling back completed actions if a
+// later one fails.
+func (c Chain) Run() error {
+	for i, a := range c {
+		if err := a.Do(); err != nil {
+			c[:i].rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Chain) rollback() {
+	for i := len(c) - 1; i >= 0; i-- {
+		if err := c[i].Undo(); err != nil {
+			grip.Errorln("error rolling back action during chain rollback:", err)
+		}
+	}
+}
+
+// funcAction adapts a do/undo pair of closures to the Action interface so
+// callers can build a Chain out of their existing single-call steps without
+// declaring a named type per action. undo may be nil for steps with no
+// side effect worth reverting (e.g. emitting an event).
+type funcAction struct {
+	do   func() error
+	undo func() error
+}
+
+func (a *funcAction) Do() error {
+	return a.do()
+}
+
+func (a *funcAction) Undo() error {
+	if a.undo == nil {
+		return nil
+	}
+	return a.undo()
+}
+
+// action builds a Chain-compatible Action out of a do/undo pair of closures.
+func action(do, undo func() error) Action {
+	return &funcAction{do: do, undo: undo}
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainRunsActionsInOrder(t *testing.T) {
+	var order []string
+
+	chain := Chain{
+		action(func() error { order = append(order, "a"); return nil }, nil),
+		action(func() error { order = append(order, "b"); return nil }, nil),
+		action(func() error { order = append(order, "c"); return nil }, nil),
+	}
+
+	assert.NoError(t, chain.Run())
+	assert.Equal(t, []string{"a", "b", "c"}, order)
+}
+
+func TestChainRollsBackCompletedActionsOnMidChainFailure(t *testing.T) {
+	var undone []string
+	boom := errors.New("boom")
+
+	chain := Chain{
+		action(
+			func() error { return nil },
+			func() error { undone = append(undone, "first"); return nil },
+		),
+		action(
+			func() error { return nil },
+			func() error { undone = append(undone, "second"); return nil },
+		),
+		action(
+			func() error { return boom },
+			func() error { undone = append(undone, "third"); return nil },
+		),
+		action(
+			func() error {
+				t.Fatal("fourth action's Do should never run after an earlier action failed")
+				return nil
+			},
+			func() error { undone = append(undone, "fourth"); return nil },
+		),
+	}
+
+	err := chain.Run()
+
+	assert.Equal(t, boom, err)
+	// only the actions that actually completed before the failure are
+	// rolled back, in reverse order; the failing action's own Undo is not
+	// called, and nothing past it ever ran.
+	assert.Equal(t, []string{"second", "first"}, undone)
+}
+
+func TestChainRollbackContinuesPastAnUndoError(t *testing.T) {
+	var undone []string
+	boom := errors.New("boom")
+
+	chain := Chain{
+		action(
+			func() error { return nil },
+			func() error { undone = append(undone, "first"); return nil },
+		),
+		action(
+			func() error { return nil },
+			func() error { return errors.New("undo failed") },
+		),
+		action(
+			func() error { return boom },
+			nil,
+		),
+	}
+
+	err := chain.Run()
+
+	assert.Equal(t, boom, err)
+	// a failing Undo shouldn't stop rollback of the actions before it.
+	assert.Equal(t, []string{"first"}, undone)
+}
+
+func TestChainNilUndoIsSafeToRollBackPast(t *testing.T) {
+	boom := errors.New("boom")
+
+	chain := Chain{
+		action(func() error { return nil }, nil),
+		action(func() error { return boom }, nil),
+	}
+
+	assert.Equal(t, boom, chain.Run())
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBisectConverged(t *testing.T) {
+	assert.True(t, bisectConverged(10, 11), "adjacent orders have no intermediate commit left to test")
+	assert.True(t, bisectConverged(10, 10))
+	assert.False(t, bisectConverged(10, 12))
+	assert.False(t, bisectConverged(10, 100))
+}
+
+func TestNarrowBisectRangeOnSuccessRaisesLow(t *testing.T) {
+	info := task.StepbackInfo{LowOrder: 10, HighOrder: 100, Caller: evergreen.StepbackTaskActivator}
+
+	low, high := narrowBisectRange(info, evergreen.TaskSucceeded, 55)
+
+	assert.Equal(t, 55, low, "a successful probe rules out everything at or before it")
+	assert.Equal(t, 100, high)
+}
+
+func TestNarrowBisectRangeOnFailureLowersHigh(t *testing.T) {
+	info := task.StepbackInfo{LowOrder: 10, HighOrder: 100, Caller: evergreen.StepbackTaskActivator}
+
+	low, high := narrowBisectRange(info, evergreen.TaskFailed, 55)
+
+	assert.Equal(t, 10, low)
+	assert.Equal(t, 55, high, "a failing probe rules out everything at or after it")
+}
+
+func TestNarrowBisectRangeConvergesAfterEnoughProbes(t *testing.T) {
+	info := task.StepbackInfo{LowOrder: 10, HighOrder: 12, Caller: evergreen.StepbackTaskActivator}
+
+	low, high := narrowBisectRange(info, evergreen.TaskFailed, 11)
+
+	assert.True(t, bisectConverged(low, high), "order 11 is the culprit once the range narrows to (10, 11)")
+}
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/tychoish/grip"
+)
+
+// TaskQuery describes a filter over tasks for bulk operations like
+// BulkRestartTasks and BulkAbortTasks. Zero-valued fields are not applied
+// as filters.
+type TaskQuery struct {
+	Project          string
+	BuildVariant     string
+	DisplayName      string
+	Requester        string
+	Statuses         []string
+	Activated        *bool
+	MinRevisionOrder int
+	MaxRevisionOrder int
+
+	// Cursor and Limit page through large result sets; Cursor is the task
+	// id to resume after, and Limit caps how many tasks are fetched.
+	Cursor string
+	Limit  int
+}
+
+// BulkTaskOutcome reports what happened to a single task as part of a bulk
+// operation.
+type BulkTaskOutcome string
+
+const (
+	BulkTaskRestarted            BulkTaskOutcome = "restarted"
+	BulkTaskAborted              BulkTaskOutcome = "aborted"
+	BulkTaskSkippedNotFinished   BulkTaskOutcome = "skipped-because-not-finished"
+	BulkTaskSkippedFinished      BulkTaskOutcome = "skipped-because-finished"
+	BulkTaskSkippedMaxExecutions BulkTaskOutcome = "skipped-because-max-executions"
+	BulkTaskError                BulkTaskOutcome = "error"
+)
+
+// BulkResult reports the per-task outcome of a bulk operation, keyed by
+// task id, so that one failing task does not fail the whole batch.
+type BulkResult struct {
+	Outcomes map[string]BulkTaskOutcome
+	Errors   map[string]string
+
+	// NextCursor is set when the query matched more tasks than Limit
+	// allowed, for resuming with a follow-up call.
+	NextCursor string
+}
+
+func newBulkResult() *BulkResult {
+	return &BulkResult{
+		Outcomes: map[string]BulkTaskOutcome{},
+		Errors:   map[string]string{},
+	}
+}
+
+func (r *BulkResult) fail(taskId string, err error) {
+	r.Outcomes[taskId] = BulkTaskError
+	r.Errors[taskId] = err.Error()
+	grip.Errorln("bulk operation failed for task", taskId, ":", err)
+}
+
+// classifyForBulkRestart determines a task's BulkTaskOutcome before even
+// attempting to restart it, or returns "" if a restart should be attempted.
+func classifyForBulkRestart(t task.Task, origin string) BulkTaskOutcome {
+	if !task.IsFinished(t) {
+		return BulkTaskSkippedNotFinished
+	}
+	if t.Execution >= evergreen.MaxTaskExecution && origin != evergreen.UIPackage {
+		return BulkTaskSkippedMaxExecutions
+	}
+	return ""
+}
+
+// classifyForBulkAbort determines a task's BulkTaskOutcome before even
+// attempting to abort it, or returns "" if an abort should be attempted.
+func classifyForBulkAbort(t task.Task) BulkTaskOutcome {
+	if task.IsFinished(t) {
+		return BulkTaskSkippedFinished
+	}
+	return ""
+}
+
+func findTasksForQuery(q TaskQuery) ([]task.Task, string, error) {
+	tasks, nextCursor, err := task.FindByQuery(task.BulkQuery{
+		Project:          q.Project,
+		BuildVariant:     q.BuildVariant,
+		DisplayName:      q.DisplayName,
+		Requester:        q.Requester,
+		Statuses:         q.Statuses,
+		Activated:        q.Activated,
+		MinRevisionOrder: q.MinRevisionOrder,
+		MaxRevisionOrder: q.MaxRevisionOrder,
+		Cursor:           q.Cursor,
+		Limit:            q.Limit,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error running task query: %v", err)
+	}
+	return tasks, nextCursor, nil
+}
+
+// BulkRestartTasks restarts every finished task matching q via the same
+// TryResetTask path the UI and REST layer already use. Unfinished tasks are
+// skipped whatever the origin; tasks at the max execution count are skipped
+// unless origin is the UI, which bypasses the restart cap as in
+// TryResetTask. Rather than failing the whole batch on the first error, it reports a
+// per-task outcome so operators can see exactly what happened to each
+// match, e.g. restarting every failed task in a version in one call.
+func BulkRestartTasks(q TaskQuery, user, origin string, p *Project) (*BulkResult, error) {
+	tasks, nextCursor, err := findTasksForQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newBulkResult()
+	result.NextCursor = nextCursor
+
+	for _, t := range tasks {
+		if outcome := classifyForBulkRestart(t, origin); outcome != "" {
+			result.Outcomes[t.Id] = outcome
+			continue
+		}
+		if err := TryResetTask(t.Id, user, origin, p, nil); err != nil {
+			result.fail(t.Id, err)
+			continue
+		}
+		result.Outcomes[t.Id] = BulkTaskRestarted
+	}
+
+	return result, nil
+}
+
+// BulkAbortTasks aborts every not-yet-finished task matching q, e.g.
+// aborting every undispatched task for a requester in one call. Rather than
+// failing the whole batch on the first error, it reports a per-task outcome.
+func BulkAbortTasks(q TaskQuery, caller string) (*BulkResult, error) {
+	tasks, nextCursor, err := findTasksForQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newBulkResult()
+	result.NextCursor = nextCursor
+
+	for _, t := range tasks {
+		if outcome := classifyForBulkAbort(t); outcome != "" {
+			result.Outcomes[t.Id] = outcome
+			continue
+		}
+		if err := AbortTask(t.Id, caller, false); err != nil {
+			result.fail(t.Id, err)
+			continue
+		}
+		result.Outcomes[t.Id] = BulkTaskAborted
+	}
+
+	return result, nil
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkSkipOutcomeLabelsAreDistinct(t *testing.T) {
+	// BulkAbortTasks skips a task because it IS finished, while
+	// BulkRestartTasks skips one because it is NOT finished; the two
+	// outcomes must stay distinct values.
+	assert.NotEqual(t, BulkTaskSkippedFinished, BulkTaskSkippedNotFinished)
+}
+
+func TestClassifyForBulkAbortSkipsFinishedTasks(t *testing.T) {
+	finished := task.Task{Id: "t1", Status: evergreen.TaskSucceeded}
+	running := task.Task{Id: "t2", Status: evergreen.TaskStarted}
+
+	assert.Equal(t, BulkTaskSkippedFinished, classifyForBulkAbort(finished),
+		"a finished task should be skipped with the 'finished' outcome, not 'not finished'")
+	assert.Equal(t, BulkTaskOutcome(""), classifyForBulkAbort(running),
+		"a not-yet-finished task should proceed to abort")
+}
+
+func TestClassifyForBulkRestartSkipsUnfinishedAndExhaustedTasks(t *testing.T) {
+	running := task.Task{Id: "t1", Status: evergreen.TaskStarted}
+	exhausted := task.Task{Id: "t2", Status: evergreen.TaskFailed, Execution: evergreen.MaxTaskExecution}
+	restartable := task.Task{Id: "t3", Status: evergreen.TaskFailed, Execution: 1}
+
+	assert.Equal(t, BulkTaskSkippedNotFinished, classifyForBulkRestart(running, "rest"))
+	assert.Equal(t, BulkTaskSkippedMaxExecutions, classifyForBulkRestart(exhausted, "rest"))
+	assert.Equal(t, BulkTaskOutcome(""), classifyForBulkRestart(restartable, "rest"))
+}
+
+func TestClassifyForBulkRestartUIOriginBypassesMaxExecutions(t *testing.T) {
+	exhausted := task.Task{Id: "t1", Status: evergreen.TaskFailed, Execution: evergreen.MaxTaskExecution}
+
+	assert.Equal(t, BulkTaskOutcome(""), classifyForBulkRestart(exhausted, evergreen.UIPackage),
+		"the UI's restart-cap exception should carry over to bulk restarts")
+}
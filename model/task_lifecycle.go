@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/evergreen-ci/evergreen"
@@ -14,7 +15,21 @@ import (
 	"github.com/tychoish/grip"
 )
 
-func SetActiveState(taskId string, caller string, active bool) error {
+// Stepback modes control how doStepback picks which intermediate commit to
+// activate after a failure. StepbackLinear walks backward one revision at a
+// time; StepbackBisect jumps to the midpoint of the unactivated range so a
+// long queue of commits converges on the culprit in O(log n) activations.
+const (
+	StepbackLinear = "linear"
+	StepbackBisect = "bisect"
+)
+
+// SetActiveState activates or deactivates taskId. If propagateToDependents
+// is true and the task is being deactivated, every not-yet-finished task
+// that transitively depends on it is deactivated too (see propagateTaskTree);
+// existing callers that pass false keep the original one-hop behavior, which
+// only ever touches taskId's own dependencies, not its dependents.
+func SetActiveState(taskId string, caller string, active bool, propagateToDependents bool) error {
 	t, err := task.FindOne(task.ById(taskId))
 	if err != nil {
 		return err
@@ -23,23 +38,11 @@ func SetActiveState(taskId string, caller string, active bool) error {
 		// if the task is being activated, make sure to activate all of the task's
 		// dependencies as well
 		for _, dep := range t.DependsOn {
-			if err = SetActiveState(dep.TaskId, caller, true); err != nil {
+			if err = SetActiveState(dep.TaskId, caller, true, false); err != nil {
 				return fmt.Errorf("error activating dependency for %v with id %v: %v",
 					taskId, dep.TaskId, err)
 			}
 		}
-
-		if t.DispatchTime != util.ZeroTime && t.Status == evergreen.TaskUndispatched {
-			err = resetTask(t.Id)
-			if err != nil {
-				return fmt.Errorf("error resetting task: %v:", err.Error())
-			}
-		} else {
-			err = t.ActivateTask(caller)
-			if err != nil {
-				return fmt.Errorf("error while activating task: %v", err.Error())
-			}
-		}
 		// If the task was not activated by step back, and either the caller is not evergreen
 		// or the task was originally activated by evergreen, deactivate the task
 	} else if !evergreen.IsSystemActivator(caller) || evergreen.IsSystemActivator(t.ActivatedBy) {
@@ -48,21 +51,61 @@ func SetActiveState(taskId string, caller string, active bool) error {
 		// If it is not, then we can deactivate it.
 		// Otherwise, if it was originally activated by evergreen, anything can
 		// decativate it.
-
-		err = t.DeactivateTask(caller)
-		if err != nil {
-			return fmt.Errorf("error deactivating task : %v:", err.Error())
-		}
 	} else {
 		return nil
 	}
 
+	// preImage is a full snapshot of the task document before this
+	// transition, so rollback can restore it exactly rather than only
+	// reverting the handful of fields an earlier action happened to touch.
+	preImage := *t
+	willReset := active && t.DispatchTime != util.ZeroTime && t.Status == evergreen.TaskUndispatched
+
+	chain := Chain{
+		action(
+			func() error {
+				if active {
+					if willReset {
+						return resetTask(t.Id)
+					}
+					return t.ActivateTask(caller)
+				}
+				return t.DeactivateTask(caller)
+			},
+			func() error {
+				if willReset {
+					// resetTask archived the task's prior execution; undo
+					// that too, or rollback would leave a phantom archived
+					// copy behind even though the live task is restored.
+					if err := task.RemoveArchivedExecution(t.Id, preImage.Execution); err != nil {
+						grip.Errorln("error removing archived execution while rolling back activation of", t.Id, ":", err)
+					}
+				}
+				return task.RestoreTask(t.Id, &preImage)
+			},
+		),
+		action(
+			func() error { return build.SetCachedTaskActivated(t.BuildId, taskId, active) },
+			func() error { return build.SetCachedTaskActivated(t.BuildId, taskId, preImage.Activated) },
+		),
+	}
+
+	if err := chain.Run(); err != nil {
+		return err
+	}
+
+	// only log the activation/deactivation once the transition has fully
+	// committed, so a rolled-back transition never shows up on dashboards.
 	if active {
 		event.LogTaskActivated(taskId, caller)
 	} else {
 		event.LogTaskDeactivated(taskId, caller)
 	}
-	return build.SetCachedTaskActivated(t.BuildId, taskId, active)
+
+	if !active && propagateToDependents {
+		return propagateTaskTree(t.Id, caller, deactivateDependent)
+	}
+	return nil
 }
 
 // ActivatePreviousTask will set the Active state for the first task with a
@@ -85,7 +128,7 @@ func ActivatePreviousTask(taskId, caller string) error {
 		return nil
 	}
 	// activate the task
-	if err = SetActiveState(prevTask.Id, caller, true); err != nil {
+	if err = SetActiveState(prevTask.Id, caller, true, false); err != nil {
 		return err
 	}
 	return nil
@@ -98,16 +141,62 @@ func resetTask(taskId string) error {
 		return err
 	}
 
-	if err := t.Archive(); err != nil {
-		return fmt.Errorf("Can't restart task because it can't be archived: %v", err)
-	}
-
-	if err = t.Reset(); err != nil {
-		return err
-	}
-
-	// update the cached version of the task, in its build document
-	if err = build.ResetCachedTask(t.BuildId, t.Id); err != nil {
+	// preImage is a full snapshot of the task document before Archive and
+	// Reset run, so rollback can put the live document back exactly as it
+	// was rather than only restoring the status and details Reset happens
+	// to touch.
+	preImage := *t
+	archivedExecution := t.Execution
+
+	chain := Chain{
+		// Archive is its own action so that if the Reset step below fails,
+		// Chain's rollback actually runs this action's Undo and removes the
+		// archived execution, instead of leaving it orphaned because Chain
+		// only rolls back actions that came before the one that failed.
+		action(
+			func() error {
+				if err := t.Archive(); err != nil {
+					return fmt.Errorf("Can't restart task because it can't be archived: %v", err)
+				}
+				return nil
+			},
+			func() error {
+				return task.RemoveArchivedExecution(t.Id, archivedExecution)
+			},
+		),
+		action(
+			func() error {
+				if err := t.Reset(); err != nil {
+					return err
+				}
+				// a reset task is starting over; any in-progress bisection
+				// state and any AbortedParent marker belong to the execution
+				// that just got archived, so drop them rather than have
+				// MarkEnd spuriously resume bisecting, or suppress retry on
+				// a real regression, for the reset task's next failure. Both
+				// are best-effort: failing to clear them doesn't invalidate
+				// the Reset itself, so they're logged rather than rolled back.
+				if err := task.ClearStepbackInfo(t.Id); err != nil {
+					grip.Errorln("error clearing stepback info while resetting", t.Id, ":", err)
+				}
+				if err := task.ClearAbortedParent(t.Id); err != nil {
+					grip.Errorln("error clearing aborted-parent marker while resetting", t.Id, ":", err)
+				}
+				return nil
+			},
+			func() error {
+				return task.RestoreTask(t.Id, &preImage)
+			},
+		),
+		action(
+			func() error { return build.ResetCachedTask(t.BuildId, t.Id) },
+			func() error {
+				return build.SetCachedTaskFinished(t.BuildId, t.Id, &preImage.Details, preImage.TimeTaken)
+			},
+		),
+	}
+
+	if err := chain.Run(); err != nil {
 		return err
 	}
 
@@ -163,7 +252,11 @@ func TryResetTask(taskId, user, origin string, p *Project, detail *apimodels.Tas
 	return err
 }
 
-func AbortTask(taskId, caller string) error {
+// AbortTask aborts taskId. If propagateToDependents is true, every
+// not-yet-finished task that transitively depends on it is aborted too (see
+// propagateTaskTree); existing callers that pass false keep the original
+// one-hop behavior.
+func AbortTask(taskId, caller string, propagateToDependents bool) error {
 	t, err := task.FindOne(task.ById(taskId))
 	if err != nil {
 		return err
@@ -175,12 +268,21 @@ func AbortTask(taskId, caller string) error {
 	}
 
 	grip.Debugln("Aborting task", t.Id)
-	// set the active state and then set the abort
-	if err = SetActiveState(t.Id, caller, false); err != nil {
+	// set the active state and then set the abort; the task's own
+	// deactivation never itself propagates, that happens below, once, after
+	// the task is confirmed aborted.
+	if err = SetActiveState(t.Id, caller, false, false); err != nil {
 		return err
 	}
 	event.LogTaskAbortRequest(t.Id, caller)
-	return t.SetAborted()
+	if err := t.SetAborted(); err != nil {
+		return err
+	}
+
+	if propagateToDependents {
+		return propagateTaskTree(t.Id, caller, abortDependent)
+	}
+	return nil
 }
 
 // Deactivate any previously activated but undispatched
@@ -198,7 +300,7 @@ func DeactivatePreviousTasks(taskId, caller string) error {
 		return err
 	}
 	for _, t := range allTasks {
-		err = SetActiveState(t.Id, caller, false)
+		err = SetActiveState(t.Id, caller, false, false)
 		if err != nil {
 			return err
 		}
@@ -241,8 +343,37 @@ func getStepback(taskId string, project *Project) (bool, error) {
 	return project.Stepback, nil
 }
 
+// getStepbackMode returns the stepback mode (linear or bisect) for a task,
+// resolved with the same task -> buildvariant -> project precedence as
+// getStepback.
+func getStepbackMode(taskId string, project *Project) (string, error) {
+	t, err := task.FindOne(task.ById(taskId))
+	if err != nil {
+		return StepbackLinear, err
+	}
+
+	projectTask := project.FindProjectTask(t.DisplayName)
+	if projectTask != nil && projectTask.StepbackMode != "" {
+		return projectTask.StepbackMode, nil
+	}
+
+	for _, buildVariant := range project.BuildVariants {
+		if t.BuildVariant == buildVariant.Name {
+			if buildVariant.StepbackMode != "" {
+				return buildVariant.StepbackMode, nil
+			}
+			break
+		}
+	}
+
+	if project.StepbackMode != "" {
+		return project.StepbackMode, nil
+	}
+	return StepbackLinear, nil
+}
+
 // doStepBack performs a stepback on the task if there is a previous task and if not it returns nothing.
-func doStepback(t *task.Task, detail *apimodels.TaskEndDetail, deactivatePrevious bool) error {
+func doStepback(t *task.Task, detail *apimodels.TaskEndDetail, deactivatePrevious bool, mode string) error {
 	//See if there is a prior success for this particular task.
 	//If there isn't, we should not activate the previous task because
 	//it could trigger stepping backwards ad infinitum.
@@ -254,10 +385,148 @@ func doStepback(t *task.Task, detail *apimodels.TaskEndDetail, deactivatePreviou
 		return fmt.Errorf("Error locating previous successful task: %v", err)
 	}
 
+	if mode == StepbackBisect {
+		// no task has StepbackInfo set yet at this point, so there's
+		// nothing to clear if the range is already converged.
+		return activateBisectMidpoint("", t.Project, t.BuildVariant, t.DisplayName, t.Requester,
+			prevTask.RevisionOrderNumber, t.RevisionOrderNumber, evergreen.StepbackTaskActivator)
+	}
+
 	// activate the previous task to pinpoint regression
 	return ActivatePreviousTask(t.Id, evergreen.StepbackTaskActivator)
 }
 
+// bisectConverged reports whether a bisection range has collapsed to the
+// point where there's no intermediate commit left to test.
+func bisectConverged(low, high int) bool {
+	return high-low <= 1
+}
+
+// narrowBisectRange narrows a bisection range given the outcome of the task
+// that was activated at order, per the rule that a success rules out
+// everything at or before order, and a failure rules out everything at or
+// after it.
+func narrowBisectRange(info task.StepbackInfo, status string, order int) (low, high int) {
+	low, high = info.LowOrder, info.HighOrder
+	if status == evergreen.TaskSucceeded {
+		low = order
+	} else {
+		high = order
+	}
+	return low, high
+}
+
+// findBisectionCulprit looks up the single task of the given (project,
+// buildvariant, displayname, requester) signature at exactly order, by
+// asking for the range strictly between order-1 and order+1.
+func findBisectionCulprit(project, buildVariant, displayName, requester string, order int) (*task.Task, error) {
+	candidates, err := task.Find(task.ByRevisionRange(project, buildVariant, displayName, requester, order-1, order+1))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range candidates {
+		if c.RevisionOrderNumber == order {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// logBisectConverged clears the in-progress bisection state recorded on
+// taskId, if any, and logs the task that a converged bisection range has
+// pinned down as the actual regression. That's always the task at
+// culpritOrder: narrowBisectRange only ever lowers high to the order of a
+// probe that failed, so whatever sits at culpritOrder is known-failing even
+// when the probe that triggered convergence itself succeeded, in which case
+// it's a different task than the one reporting convergence.
+func logBisectConverged(taskId, project, buildVariant, displayName, requester string, culpritOrder int, caller string) error {
+	if taskId != "" {
+		if err := task.ClearStepbackInfo(taskId); err != nil {
+			grip.Errorln("error clearing stepback info for converged task", taskId, ":", err)
+		}
+	}
+
+	culpritId := taskId
+	culprit, err := findBisectionCulprit(project, buildVariant, displayName, requester, culpritOrder)
+	if err != nil {
+		grip.Errorln("error resolving bisection culprit at order", culpritOrder, "for", buildVariant, displayName, ":", err)
+	} else if culprit != nil {
+		culpritId = culprit.Id
+	}
+
+	event.LogStepbackConverged(culpritId, caller)
+	grip.Noticef("StepbackConverged: %v is the first failing revision for %v/%v", culpritId, buildVariant, displayName)
+	return nil
+}
+
+// activateBisectMidpoint finds every unactivated task sharing the given
+// (project, buildvariant, displayname, requester) signature whose revision
+// order number falls strictly between low and high, and activates the
+// midpoint of that range rather than the immediate predecessor. The
+// bisection range is persisted on the activated task so that its eventual
+// MarkEnd can narrow the range further. taskId identifies the task that
+// currently holds the in-progress StepbackInfo being narrowed, if any, so it
+// can be cleared if the range turns out to already be converged; pass "" if
+// no task holds one yet.
+func activateBisectMidpoint(taskId, project, buildVariant, displayName, requester string, low, high int, caller string) error {
+	if bisectConverged(low, high) {
+		return logBisectConverged(taskId, project, buildVariant, displayName, requester, high, caller)
+	}
+
+	candidates, err := task.Find(task.ByRevisionRange(project, buildVariant, displayName, requester, low, high))
+	if err != nil {
+		return fmt.Errorf("error finding intermediate tasks for bisection: %v", err)
+	}
+	if len(candidates) == 0 {
+		// no task with this signature exists strictly between low and high
+		// (e.g. the build variant didn't run on every intermediate commit),
+		// so there's no narrower midpoint left to probe. This is a normal
+		// terminal case for bisection, not an error, and still needs to
+		// report the culprit like any other convergence.
+		return logBisectConverged(taskId, project, buildVariant, displayName, requester, high, caller)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].RevisionOrderNumber < candidates[j].RevisionOrderNumber
+	})
+
+	mid := candidates[len(candidates)/2]
+
+	if err = SetActiveState(mid.Id, caller, true, false); err != nil {
+		return err
+	}
+
+	// persisted after activation: activating a previously dispatched task
+	// goes through resetTask, which clears any StepbackInfo on it.
+	if err = task.SetStepbackInfo(mid.Id, task.StepbackInfo{
+		LowOrder:  low,
+		HighOrder: high,
+		Caller:    caller,
+	}); err != nil {
+		return fmt.Errorf("error persisting stepback bisection range on %v: %v", mid.Id, err)
+	}
+	return nil
+}
+
+// continueBisectStepback narrows a task's in-progress bisection range based
+// on the outcome recorded in detail, and either reactivates the new midpoint
+// or, once the range has collapsed to a single commit, logs the task that
+// introduced the regression.
+func continueBisectStepback(t *task.Task, detail *apimodels.TaskEndDetail) error {
+	info := t.StepbackInfo
+	if info == nil {
+		return nil
+	}
+
+	low, high := narrowBisectRange(*info, detail.Status, t.RevisionOrderNumber)
+
+	if bisectConverged(low, high) {
+		return logBisectConverged(t.Id, t.Project, t.BuildVariant, t.DisplayName, t.Requester, high, info.Caller)
+	}
+
+	return activateBisectMidpoint(t.Id, t.Project, t.BuildVariant, t.DisplayName, t.Requester, low, high, info.Caller)
+}
+
 // MarkEnd updates the task as being finished, performs a stepback if necessary, and updates the build status
 func MarkEnd(taskId, caller string, finishTime time.Time, detail *apimodels.TaskEndDetail, p *Project, deactivatePrevious bool) error {
 
@@ -274,19 +543,30 @@ func MarkEnd(taskId, caller string, finishTime time.Time, detail *apimodels.Task
 		return nil
 	}
 
+	// preImage is a full snapshot of the task document before MarkEnd
+	// mutates it, so rollback can restore it exactly.
+	preImage := *t
 	t.Details = *detail
 
-	err = t.MarkEnd(caller, finishTime, detail)
-	if err != nil {
-		return err
+	chain := Chain{
+		action(
+			func() error { return t.MarkEnd(caller, finishTime, detail) },
+			func() error { return task.RestoreTask(t.Id, &preImage) },
+		),
+		action(
+			func() error { return build.SetCachedTaskFinished(t.BuildId, t.Id, detail, t.TimeTaken) },
+			func() error {
+				return build.SetCachedTaskFinished(t.BuildId, t.Id, &preImage.Details, preImage.TimeTaken)
+			},
+		),
 	}
-	event.LogTaskFinished(t.Id, t.HostId, detail.Status)
 
-	// update the cached version of the task, in its build document
-	err = build.SetCachedTaskFinished(t.BuildId, t.Id, detail, t.TimeTaken)
-	if err != nil {
+	if err = chain.Run(); err != nil {
 		return fmt.Errorf("error updating build: %v", err.Error())
 	}
+	// only log once the transition has fully committed, so a rolled-back
+	// MarkEnd never shows up as a finished-task event on dashboards.
+	event.LogTaskFinished(t.Id, t.HostId, detail.Status)
 
 	// no need to activate/deactivate other task if this is a patch request's task
 	if t.Requester == evergreen.PatchVersionRequester {
@@ -296,18 +576,42 @@ func MarkEnd(taskId, caller string, finishTime time.Time, detail *apimodels.Task
 		}
 		return nil
 	}
-	if detail.Status == evergreen.TaskFailed {
-		shouldStepBack, err := getStepback(t.Id, p)
-		if err != nil {
-			return err
+	if t.StepbackInfo != nil {
+		if err = continueBisectStepback(t, detail); err != nil {
+			return fmt.Errorf("Error continuing stepback bisection: %v", err.Error())
 		}
-		if shouldStepBack {
-			err = doStepback(t, detail, deactivatePrevious)
+	} else if detail.Status == evergreen.TaskFailed {
+		if t.AbortedParent != "" {
+			// this task was left running after one of its dependencies was
+			// aborted or deactivated; its failure is expected and should not
+			// be auto-retried or trigger a stepback against the now-cancelled
+			// parent.
+			grip.Debugln("Not auto-retrying or stepping back task with an aborted parent:",
+				t.Id, "parent:", t.AbortedParent)
+		} else {
+			retried, err := maybeScheduleAutoRetry(t, detail, p)
 			if err != nil {
-				return fmt.Errorf("Error during step back: %v", err.Error())
+				return fmt.Errorf("Error scheduling auto-retry: %v", err.Error())
+			}
+
+			if !retried {
+				shouldStepBack, err := getStepback(t.Id, p)
+				if err != nil {
+					return err
+				}
+				if shouldStepBack {
+					mode, err := getStepbackMode(t.Id, p)
+					if err != nil {
+						return err
+					}
+					err = doStepback(t, detail, deactivatePrevious, mode)
+					if err != nil {
+						return fmt.Errorf("Error during step back: %v", err.Error())
+					}
+				} else {
+					grip.Debugln("Not stepping backwards on task failure:", t.Id)
+				}
 			}
-		} else {
-			grip.Debugln("Not stepping backwards on task failure:", t.Id)
 		}
 
 	} else if deactivatePrevious {
@@ -510,30 +814,51 @@ func MarkStart(taskId string) error {
 		return err
 	}
 	startTime := time.Now()
-	if err = t.MarkStart(startTime); err != nil {
-		return err
-	}
-	event.LogTaskStarted(t.Id)
 
-	// ensure the appropriate build is marked as started if necessary
-	if err = build.TryMarkStarted(t.BuildId, startTime); err != nil {
-		return err
-	}
-
-	// ensure the appropriate version is marked as started if necessary
-	if err = MarkVersionStarted(t.Version, startTime); err != nil {
-		return err
+	chain := Chain{
+		action(
+			func() error { return t.MarkStart(startTime) },
+			func() error { return task.UnmarkStart(t.Id) },
+		),
+		// build.TryMarkStarted, MarkVersionStarted, patch.TryMarkStarted, and
+		// build.SetCachedTaskStarted below have no compensating Undo: if a
+		// later step in this chain fails, only the task's own start is rolled
+		// back, and a build, version or patch already marked as started
+		// stays marked as started.
+		//
+		// ensure the appropriate build is marked as started if necessary
+		action(
+			func() error { return build.TryMarkStarted(t.BuildId, startTime) },
+			nil,
+		),
+		// ensure the appropriate version is marked as started if necessary
+		action(
+			func() error { return MarkVersionStarted(t.Version, startTime) },
+			nil,
+		),
 	}
 
 	// if it's a patch, mark the patch as started if necessary
 	if t.Requester == evergreen.PatchVersionRequester {
-		if err = patch.TryMarkStarted(t.Version, startTime); err != nil {
-			return err
-		}
+		chain = append(chain, action(
+			func() error { return patch.TryMarkStarted(t.Version, startTime) },
+			nil,
+		))
 	}
 
 	// update the cached version of the task, in its build document
-	return build.SetCachedTaskStarted(t.BuildId, t.Id, startTime)
+	chain = append(chain, action(
+		func() error { return build.SetCachedTaskStarted(t.BuildId, t.Id, startTime) },
+		nil,
+	))
+
+	if err := chain.Run(); err != nil {
+		return err
+	}
+	// only log once the transition has fully committed, so a rolled-back
+	// MarkStart never shows up as a started-task event on dashboards.
+	event.LogTaskStarted(t.Id)
+	return nil
 }
 
 func MarkTaskUndispatched(t *task.Task) error {
@@ -552,17 +877,35 @@ func MarkTaskUndispatched(t *task.Task) error {
 }
 
 func MarkTaskDispatched(t *task.Task, hostId, distroId string) error {
-	// record that the task was dispatched on the host
-	if err := t.MarkAsDispatched(hostId, distroId, time.Now()); err != nil {
-		return fmt.Errorf("error marking task %v as dispatched "+
-			"on host %v: %v", t.Id, hostId, err)
+	chain := Chain{
+		// record that the task was dispatched on the host
+		action(
+			func() error {
+				if err := t.MarkAsDispatched(hostId, distroId, time.Now()); err != nil {
+					return fmt.Errorf("error marking task %v as dispatched "+
+						"on host %v: %v", t.Id, hostId, err)
+				}
+				return nil
+			},
+			func() error { return t.MarkAsUndispatched() },
+		),
+		// update the cached version of the task in its related build document
+		action(
+			func() error {
+				if err := build.SetCachedTaskDispatched(t.BuildId, t.Id); err != nil {
+					return fmt.Errorf("error updating task cache in build %v: %v", t.BuildId, err)
+				}
+				return nil
+			},
+			func() error { return build.SetCachedTaskUndispatched(t.BuildId, t.Id) },
+		),
 	}
-	// the task was successfully dispatched, log the event
-	event.LogTaskDispatched(t.Id, hostId)
 
-	// update the cached version of the task in its related build document
-	if err := build.SetCachedTaskDispatched(t.BuildId, t.Id); err != nil {
-		return fmt.Errorf("error updating task cache in build %v: %v", t.BuildId, err)
+	if err := chain.Run(); err != nil {
+		return err
 	}
+	// only log once the transition has fully committed, so a rolled-back
+	// dispatch never shows up as a dispatched-task event on dashboards.
+	event.LogTaskDispatched(t.Id, hostId)
 	return nil
 }
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/evergreen-ci/evergreen"
+	"github.com/evergreen-ci/evergreen/apimodels"
+	"github.com/evergreen-ci/evergreen/model/event"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/tychoish/grip"
+)
+
+// RetryPolicy configures automatic restarts for tasks that fail with a
+// retryable apimodels.FailureClass, so flaky infrastructure failures don't
+// have to wait on a human to restart them. Attempts are counted per
+// FailureClass against MaxAttempts; NextRetryAt is computed as
+// Base * Backoff^attempt (or just Base when Backoff is not positive) plus up
+// to Jitter of random slop, and Budget caps
+// the total time a task may spend being auto-retried.
+type RetryPolicy struct {
+	MaxAttempts map[apimodels.FailureClass]int
+	Base        time.Duration
+	Backoff     float64
+	Jitter      time.Duration
+	Budget      time.Duration
+}
+
+// getRetryPolicy resolves a task's RetryPolicy with the same
+// task -> buildvariant -> project precedence as getStepback.
+func getRetryPolicy(taskId string, project *Project) (*RetryPolicy, error) {
+	t, err := task.FindOne(task.ById(taskId))
+	if err != nil {
+		return nil, err
+	}
+
+	projectTask := project.FindProjectTask(t.DisplayName)
+	if projectTask != nil && projectTask.RetryPolicy != nil {
+		return projectTask.RetryPolicy, nil
+	}
+
+	for _, buildVariant := range project.BuildVariants {
+		if t.BuildVariant == buildVariant.Name {
+			if buildVariant.RetryPolicy != nil {
+				return buildVariant.RetryPolicy, nil
+			}
+			break
+		}
+	}
+
+	return project.RetryPolicy, nil
+}
+
+// retryAttempts returns how many times t has already been auto-retried for
+// class specifically, independent of t.Execution (which counts every
+// execution of the task, including ones that failed under a different
+// class) and independent of attempts made for any other class.
+func retryAttempts(t *task.Task, class apimodels.FailureClass) int {
+	if t.RetryState == nil {
+		return 0
+	}
+	return t.RetryState.Attempts[class]
+}
+
+// retryBudgetSpent returns how much cumulative time t has already spent
+// being auto-retried, across all classes.
+func retryBudgetSpent(t *task.Task) time.Duration {
+	if t.RetryState == nil {
+		return 0
+	}
+	return t.RetryState.BudgetSpent
+}
+
+// computeBackoff returns the delay before the next retry attempt, given
+// that the class being retried has already been attempted `attempt` times.
+func computeBackoff(policy *RetryPolicy, attempt int) time.Duration {
+	if policy.Backoff <= 0 {
+		return policy.Base
+	}
+	return time.Duration(float64(policy.Base) * math.Pow(policy.Backoff, float64(attempt)))
+}
+
+// retryWithinBudget reports whether spending wait on top of the time already
+// spent auto-retrying would stay within the policy's overall Budget. Callers
+// must pass the same duration they're about to record as spent (i.e.
+// including jitter), or the budget check and the recorded spend will drift
+// apart. A zero Budget means no cap.
+func retryWithinBudget(policy *RetryPolicy, spent, wait time.Duration) bool {
+	if policy.Budget <= 0 {
+		return true
+	}
+	return spent+wait <= policy.Budget
+}
+
+// maybeScheduleAutoRetry checks whether t's failure is retryable under the
+// resolved RetryPolicy and, if so, records a NextRetryAt on the task rather
+// than restarting it immediately. It returns true if a retry was scheduled,
+// in which case the caller should skip its usual failure handling (e.g.
+// stepback) since the task is expected to run again on its own.
+func maybeScheduleAutoRetry(t *task.Task, detail *apimodels.TaskEndDetail, p *Project) (bool, error) {
+	policy, err := getRetryPolicy(t.Id, p)
+	if err != nil {
+		return false, err
+	}
+	if policy == nil {
+		return false, nil
+	}
+
+	attempts := retryAttempts(t, detail.FailureClass)
+	maxAttempts, ok := policy.MaxAttempts[detail.FailureClass]
+	if !ok || maxAttempts <= 0 || attempts >= maxAttempts {
+		return false, nil
+	}
+
+	backoff := computeBackoff(policy, attempts)
+
+	var jitter time.Duration
+	if policy.Jitter > 0 {
+		jitter = time.Duration(rand.Int63n(int64(policy.Jitter)))
+	}
+	wait := backoff + jitter
+
+	// the budget check has to use wait, not backoff, since wait (including
+	// jitter) is what RecordRetryAttempt below adds to cumulative spend;
+	// checking against backoff alone let actual spend creep past Budget by
+	// up to Jitter on every attempt.
+	spent := retryBudgetSpent(t)
+	if !retryWithinBudget(policy, spent, wait) {
+		grip.Noticef("Not auto-retrying task %v: cumulative retry time %v would exceed budget %v",
+			t.Id, spent+wait, policy.Budget)
+		return false, nil
+	}
+
+	nextRetryAt := time.Now().Add(wait)
+	if err := task.SetNextRetryAt(t.Id, nextRetryAt); err != nil {
+		return false, fmt.Errorf("error setting next retry time for %v: %v", t.Id, err)
+	}
+	if err := task.RecordRetryAttempt(t.Id, detail.FailureClass, wait); err != nil {
+		return false, fmt.Errorf("error recording retry attempt for %v: %v", t.Id, err)
+	}
+
+	grip.Noticef("Scheduled auto-retry for task %v (class '%v', attempt %v) at %v",
+		t.Id, detail.FailureClass, attempts+1, nextRetryAt)
+	return true, nil
+}
+
+// PromoteDueRetries finds every task whose scheduled auto-retry time has
+// passed and restarts it, attributing the restart to the auto-retry system
+// activator so dashboards can distinguish flaky-infra retries from human
+// restarts. It is meant to be called periodically by a scheduler loop.
+func PromoteDueRetries() error {
+	tasks, err := task.Find(task.ByNextRetryBefore(time.Now()))
+	if err != nil {
+		return fmt.Errorf("error finding tasks due for auto-retry: %v", err)
+	}
+
+	for _, t := range tasks {
+		if err := task.ClearNextRetryAt(t.Id); err != nil {
+			grip.Errorln("error clearing next retry time for", t.Id, ":", err)
+			continue
+		}
+		if err := resetTask(t.Id); err != nil {
+			grip.Errorln("error auto-retrying task", t.Id, ":", err)
+			continue
+		}
+		event.LogTaskRestarted(t.Id, evergreen.AutoRetryActivator)
+		if err := SetActiveState(t.Id, evergreen.AutoRetryActivator, true, false); err != nil {
+			grip.Errorln("error activating auto-retried task", t.Id, ":", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/apimodels"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAttemptsAreCountedPerClass(t *testing.T) {
+	tsk := &task.Task{
+		Execution: 5, // other classes have already failed several times
+		RetryState: &task.RetryState{
+			Attempts: map[apimodels.FailureClass]int{
+				apimodels.FailureClassSystem: 3,
+			},
+		},
+	}
+
+	assert.Equal(t, 3, retryAttempts(tsk, apimodels.FailureClassSystem))
+	assert.Equal(t, 0, retryAttempts(tsk, apimodels.FailureClassTest),
+		"a class with no recorded attempts should not inherit t.Execution or another class's count")
+}
+
+func TestComputeBackoffIsExponentialPerClassAttempt(t *testing.T) {
+	policy := &RetryPolicy{Base: time.Second, Backoff: 2}
+
+	assert.Equal(t, time.Second, computeBackoff(policy, 0))
+	assert.Equal(t, 2*time.Second, computeBackoff(policy, 1))
+	assert.Equal(t, 4*time.Second, computeBackoff(policy, 2))
+}
+
+func TestComputeBackoffWithoutMultiplierIsConstant(t *testing.T) {
+	policy := &RetryPolicy{Base: 30 * time.Second}
+
+	assert.Equal(t, 30*time.Second, computeBackoff(policy, 0))
+	assert.Equal(t, 30*time.Second, computeBackoff(policy, 4))
+}
+
+func TestRetryWithinBudgetComparesCumulativeSpend(t *testing.T) {
+	policy := &RetryPolicy{Budget: 100 * time.Second}
+
+	// individual interval is under budget, but added to what's already
+	// been spent it would blow the cumulative total.
+	assert.False(t, retryWithinBudget(policy, 90*time.Second, 20*time.Second))
+	assert.True(t, retryWithinBudget(policy, 50*time.Second, 20*time.Second))
+}
+
+func TestRetryWithinBudgetZeroMeansUnbounded(t *testing.T) {
+	policy := &RetryPolicy{}
+	assert.True(t, retryWithinBudget(policy, 10*time.Hour, 10*time.Hour))
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/evergreen-ci/evergreen/model/event"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/evergreen-ci/evergreen/util"
+	"github.com/tychoish/grip"
+)
+
+// maxTaskTreeDepth bounds how far the propagateToDependents option on
+// AbortTask and SetActiveState will walk down a dependency tree, so a
+// pathological project with a deep or cyclic dependency graph can't turn
+// one abort/deactivate into unbounded work.
+const maxTaskTreeDepth = 50
+
+// findDependentTasksFunc is a package-level indirection over
+// task.FindDependentTasks so tests can substitute a fake dependency graph
+// without a database.
+var findDependentTasksFunc = task.FindDependentTasks
+
+// propagateTaskTree walks every not-yet-finished task that (transitively)
+// depends on taskId and applies apply to each, exactly once, up to
+// maxTaskTreeDepth levels deep. It backs the propagateToDependents option on
+// AbortTask and SetActiveState. In both cases, a dependent that's already
+// dispatched is left running rather than killed mid-flight, but
+// propagateTaskTree marks it with AbortedParent so MarkEnd knows not to
+// treat its eventual failure as a real regression against a parent that
+// was itself cancelled.
+func propagateTaskTree(taskId, caller string, apply func(t *task.Task, caller string) error) error {
+	visited := map[string]bool{taskId: true}
+	return propagateTaskTreeAtDepth(taskId, caller, visited, 0, apply)
+}
+
+func propagateTaskTreeAtDepth(taskId, caller string, visited map[string]bool, depth int, apply func(*task.Task, string) error) error {
+	if depth >= maxTaskTreeDepth {
+		grip.Warningf("task tree propagation from %v exceeded max depth %v, stopping early", taskId, maxTaskTreeDepth)
+		return nil
+	}
+
+	dependents, err := findDependentTasksFunc(taskId)
+	if err != nil {
+		return fmt.Errorf("error finding tasks depending on %v: %v", taskId, err)
+	}
+
+	for _, dep := range dependents {
+		if visited[dep.Id] {
+			continue
+		}
+		visited[dep.Id] = true
+
+		if task.IsFinished(dep) {
+			continue
+		}
+
+		if dep.DispatchTime != util.ZeroTime {
+			// the dependent is already running; let it finish, but mark it
+			// so a later failure doesn't trigger a stepback against what is
+			// now a cancelled parent.
+			if err := task.SetAbortedParent(dep.Id, taskId); err != nil {
+				return fmt.Errorf("error marking %v as having an aborted dependency: %v", dep.Id, err)
+			}
+		} else if err := apply(&dep, caller); err != nil {
+			return err
+		}
+
+		if err := propagateTaskTreeAtDepth(dep.Id, caller, visited, depth+1, apply); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func abortDependent(t *task.Task, caller string) error {
+	if !task.IsAbortable(*t) {
+		return nil
+	}
+	// the dependent's own abort never recurses into propagateTaskTree a
+	// second time; propagateTaskTreeAtDepth is already walking the tree one
+	// level at a time and will visit this dependent's own dependents next.
+	if err := SetActiveState(t.Id, caller, false, false); err != nil {
+		return err
+	}
+	event.LogTaskAbortRequest(t.Id, caller)
+	return t.SetAborted()
+}
+
+func deactivateDependent(t *task.Task, caller string) error {
+	return SetActiveState(t.Id, caller, false, false)
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/evergreen-ci/evergreen/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropagateTaskTreeVisitsEachDependentOnce(t *testing.T) {
+	orig := findDependentTasksFunc
+	defer func() { findDependentTasksFunc = orig }()
+
+	// a -> b, a -> c, b -> d, c -> d: a diamond dependency, so d would be
+	// visited twice without the visited set guarding against it.
+	graph := map[string][]task.Task{
+		"a": {{Id: "b", DispatchTime: util.ZeroTime}, {Id: "c", DispatchTime: util.ZeroTime}},
+		"b": {{Id: "d", DispatchTime: util.ZeroTime}},
+		"c": {{Id: "d", DispatchTime: util.ZeroTime}},
+		"d": {},
+	}
+	findDependentTasksFunc = func(taskId string) ([]task.Task, error) {
+		return graph[taskId], nil
+	}
+
+	applied := map[string]int{}
+	err := propagateTaskTree("a", "me", func(dep *task.Task, caller string) error {
+		applied[dep.Id]++
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, applied["b"])
+	assert.Equal(t, 1, applied["c"])
+	assert.Equal(t, 1, applied["d"], "a diamond dependency should only be applied once")
+}
+
+func TestPropagateTaskTreeStopsAtMaxDepth(t *testing.T) {
+	orig := findDependentTasksFunc
+	defer func() { findDependentTasksFunc = orig }()
+
+	// an unbroken chain t0 -> t1 -> t2 -> ... that runs well past
+	// maxTaskTreeDepth if left unbounded.
+	findDependentTasksFunc = func(taskId string) ([]task.Task, error) {
+		var n int
+		fmt.Sscanf(taskId, "t%d", &n)
+		return []task.Task{{Id: fmt.Sprintf("t%d", n+1), DispatchTime: util.ZeroTime}}, nil
+	}
+
+	applied := 0
+	err := propagateTaskTree("t0", "me", func(dep *task.Task, caller string) error {
+		applied++
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, maxTaskTreeDepth, applied, "propagation should stop once it hits the max depth")
+}